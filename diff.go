@@ -0,0 +1,114 @@
+// Copyright 2011 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// stackSignature returns a string identifying a stack by its symbol
+// names rather than raw addresses, so stacks can be matched between two
+// profiles taken from different binary layouts (e.g. before/after a
+// rebuild). p.names must already be populated, by CleanupStacks for text
+// heap profiles or directly by ParseProto for protobuf ones. A frame
+// with no resolved name falls back to its raw address, so two unrelated
+// stacks that are both only partially symbolized (e.g. diffing across
+// builds with stripped libraries) don't collapse to the same signature.
+func stackSignature(p *Profile, stack *Stack) string {
+	parts := make([]string, len(stack.Stack))
+	for i, addr := range stack.Stack {
+		if name := p.names[addr]; name != "" {
+			parts[i] = name
+		} else {
+			parts[i] = fmt.Sprintf("0x%x", addr)
+		}
+	}
+	return strings.Join(parts, ";")
+}
+
+func negateStats(s Stats) Stats {
+	return Stats{
+		InuseBytes:   -s.InuseBytes,
+		InuseObjects: -s.InuseObjects,
+		AllocBytes:   -s.AllocBytes,
+		AllocObjects: -s.AllocObjects,
+	}
+}
+
+func subStats(s, base Stats) Stats {
+	s.InuseBytes -= base.InuseBytes
+	s.InuseObjects -= base.InuseObjects
+	s.AllocBytes -= base.AllocBytes
+	s.AllocObjects -= base.AllocObjects
+	return s
+}
+
+// DiffProfiles subtracts baseline a's per-stack stats from b's, matching
+// stacks by stackSignature rather than raw address. The result's stacks
+// (and Header) carry signed deltas: positive means b allocated more than
+// a at that stack, negative means less. Stacks present only in a (i.e.
+// freed entirely in b) show up as pure negative deltas; stacks present
+// only in b show up as pure positive ones.
+func DiffProfiles(a, b *Profile) *Profile {
+	type baseline struct {
+		stats Stats
+		stack *Stack
+	}
+	byKey := make(map[string]*baseline)
+	for _, stack := range a.stacks {
+		k := stackSignature(a, stack)
+		if bl, ok := byKey[k]; ok {
+			bl.stats.Add(stack.Stats)
+		} else {
+			byKey[k] = &baseline{stats: stack.Stats, stack: stack}
+		}
+	}
+
+	diff := &Profile{names: make(map[uint64]string), maps: b.maps}
+	seen := make(map[string]bool)
+
+	for _, stack := range b.stacks {
+		k := stackSignature(b, stack)
+		seen[k] = true
+
+		delta := stack.Stats
+		if bl, ok := byKey[k]; ok {
+			delta = subStats(delta, bl.stats)
+		}
+
+		addrs := append([]uint64(nil), stack.Stack...)
+		for _, addr := range addrs {
+			diff.names[addr] = b.names[addr]
+		}
+		diff.stacks = append(diff.stacks, &Stack{Stack: addrs, Stats: delta})
+		diff.Header.Add(delta)
+	}
+
+	for k, bl := range byKey {
+		if seen[k] {
+			continue
+		}
+		delta := negateStats(bl.stats)
+		addrs := append([]uint64(nil), bl.stack.Stack...)
+		for _, addr := range addrs {
+			diff.names[addr] = a.names[addr]
+		}
+		diff.stacks = append(diff.stacks, &Stack{Stack: addrs, Stats: delta})
+		diff.Header.Add(delta)
+	}
+
+	return diff
+}
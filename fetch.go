@@ -0,0 +1,174 @@
+// Copyright 2011 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var flag_seconds *int = flag.Int("seconds", 0, "for CPU/allocs profiles fetched over HTTP, how long to collect over (appended as ?seconds=N)")
+var flag_bearer_token *string = flag.String("bearer_token", "", "Authorization: Bearer token to send when fetching a profile over HTTP")
+var flag_cookie *string = flag.String("cookie", "", "Cookie header to send when fetching a profile over HTTP")
+
+// isHTTPURL reports whether target names a live profile endpoint rather
+// than a local file path.
+func isHTTPURL(target string) bool {
+	return strings.HasPrefix(target, "http://") || strings.HasPrefix(target, "https://")
+}
+
+// applyAuth attaches -bearer_token/-cookie to req, if set.
+func applyAuth(req *http.Request) {
+	if len(*flag_bearer_token) > 0 {
+		req.Header.Set("Authorization", "Bearer "+*flag_bearer_token)
+	}
+	if len(*flag_cookie) > 0 {
+		req.Header.Set("Cookie", *flag_cookie)
+	}
+}
+
+// FetchProfile fetches a profile from a live pprof endpoint (e.g.
+// http://host:6060/debug/pprof/heap) via net/http, whose default client
+// already follows redirects. -seconds is appended as a query param, for
+// CPU/allocs profiles that collect over a window. It returns the raw
+// body and the response's Content-Type, so the caller can tell a
+// protobuf profile from the legacy text format.
+func FetchProfile(rawURL string) (data []byte, contentType string) {
+	u, err := url.Parse(rawURL)
+	check(err)
+	if *flag_seconds > 0 {
+		q := u.Query()
+		q.Set("seconds", strconv.Itoa(*flag_seconds))
+		u.RawQuery = q.Encode()
+	}
+
+	req, err := http.NewRequest("GET", u.String(), nil)
+	check(err)
+	applyAuth(req)
+
+	client := &http.Client{}
+	if *flag_seconds > 0 {
+		client.Timeout = time.Duration(*flag_seconds+30) * time.Second
+	}
+	resp, err := client.Do(req)
+	check(err)
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		log.Fatalf("fetching %s: %s", u.String(), resp.Status)
+	}
+
+	data, err = io.ReadAll(resp.Body)
+	check(err)
+	return data, resp.Header.Get("Content-Type")
+}
+
+// resolveMissingSymbols fills in any stack address a protobuf profile
+// left unnamed (an empty Function.name, e.g. from a stripped binary) by
+// querying the server's /debug/pprof/symbol endpoint, mirroring how
+// pprof's own driver falls back to live symbolization when a profile
+// doesn't carry symbol info inline. /debug/pprof/symbol does a real
+// PC-to-name lookup, so a protobuf profile's stack keys -- synthetic
+// location ids, not real addresses -- must be translated via
+// p.locAddrs first; legacy text heap profiles have no locAddrs and
+// their stack keys already are real addresses.
+func resolveMissingSymbols(p *Profile, profileURL string) {
+	if p.names == nil {
+		return
+	}
+	missingKeys := make(map[uint64][]uint64) // real PC addr -> stack keys it names
+	for _, stack := range p.stacks {
+		for _, key := range stack.Stack {
+			if p.names[key] != "" {
+				continue
+			}
+			addr := key
+			if real, ok := p.locAddrs[key]; ok {
+				addr = real
+			}
+			missingKeys[addr] = append(missingKeys[addr], key)
+		}
+	}
+	if len(missingKeys) == 0 {
+		return
+	}
+	addrs := make([]uint64, 0, len(missingKeys))
+	for addr := range missingKeys {
+		addrs = append(addrs, addr)
+	}
+	log.Printf("resolving %d unnamed addresses via /debug/pprof/symbol", len(addrs))
+	for addr, name := range FetchSymbols(profileURL, addrs) {
+		for _, key := range missingKeys[addr] {
+			p.names[key] = name
+		}
+	}
+}
+
+// FetchSymbols resolves addrs to names using the target's
+// /debug/pprof/symbol endpoint -- the same lookup-by-address protocol
+// Go's net/http/pprof and pprof's own driver use to symbolize a profile
+// with no local debug binary. profileURL's last path element (e.g.
+// "heap") is replaced with "symbol".
+func FetchSymbols(profileURL string, addrs []uint64) map[uint64]string {
+	u, err := url.Parse(profileURL)
+	if err != nil {
+		log.Printf("symbol lookup: %v", err)
+		return nil
+	}
+	u.Path = path.Join(path.Dir(strings.TrimRight(u.Path, "/")), "symbol")
+	u.RawQuery = ""
+
+	var body strings.Builder
+	for _, addr := range addrs {
+		fmt.Fprintf(&body, "0x%x\n", addr)
+	}
+
+	req, err := http.NewRequest("POST", u.String(), strings.NewReader(body.String()))
+	if err != nil {
+		log.Printf("symbol lookup: %v", err)
+		return nil
+	}
+	applyAuth(req)
+
+	resp, err := (&http.Client{}).Do(req)
+	if err != nil {
+		log.Printf("symbol lookup: %v", err)
+		return nil
+	}
+	defer resp.Body.Close()
+
+	names := make(map[uint64]string)
+	scanner := bufio.NewScanner(resp.Body)
+	for scanner.Scan() {
+		parts := strings.SplitN(scanner.Text(), "\t", 2)
+		if len(parts) != 2 {
+			continue
+		}
+		addr, err := strconv.ParseUint(strings.TrimPrefix(parts[0], "0x"), 16, 64)
+		if err != nil {
+			continue
+		}
+		names[addr] = parts[1]
+	}
+	return names
+}
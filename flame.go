@@ -0,0 +1,116 @@
+// Copyright 2011 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// frameName returns the demangled, RemoveTypes-cleaned name for addr --
+// the same pipeline s.Label uses for display, minus the 60-char
+// truncation (Folded/FlameJSON output is data, not a graph label).
+func (s *state) frameName(addr uint64) string {
+	name := s.names[addr]
+	if len(name) == 0 {
+		return fmt.Sprintf("0x%x", addr)
+	}
+	demangled, err := s.demangler.Demangle(name)
+	check(err)
+	return RemoveTypes(demangled)
+}
+
+// Folded emits Brendan Gregg's collapsed stack format, one line per
+// stack: "root;...;leaf bytes". Pipe into flamegraph.pl or inferno.
+// stack.Stack is leaf-first (see graph.Analyze), so frames are emitted
+// in reverse.
+func (s *state) Folded(w io.Writer) {
+	for _, stack := range s.rawStacks {
+		if stack.Stats.InuseBytes == 0 {
+			continue
+		}
+		names := make([]string, len(stack.Stack))
+		for i, addr := range stack.Stack {
+			names[len(stack.Stack)-1-i] = s.frameName(addr)
+		}
+		fmt.Fprintf(w, "%s %d\n", strings.Join(names, ";"), stack.Stats.InuseBytes)
+	}
+}
+
+// flameNode is one node of the hierarchical {name,value,children} tree
+// that d3-flame-graph consumes.
+type flameNode struct {
+	Name     string       `json:"name"`
+	Value    int          `json:"value"`
+	Children []*flameNode `json:"children,omitempty"`
+}
+
+// FlameJSON writes the d3-flame-graph JSON tree, folding rawStacks into
+// a trie keyed on frameName (demangled+RemoveTypes), root-first. A
+// node's value is the total bytes of every stack passing through it.
+func (s *state) FlameJSON(w io.Writer) error {
+	root := &flameNode{Name: "root"}
+	childIndex := map[*flameNode]map[string]*flameNode{root: {}}
+
+	for _, stack := range s.rawStacks {
+		n := stack.Stats.InuseBytes
+		if n == 0 {
+			continue
+		}
+		node := root
+		node.Value += n
+		for i := len(stack.Stack) - 1; i >= 0; i-- {
+			name := s.frameName(stack.Stack[i])
+			idx := childIndex[node]
+			child, ok := idx[name]
+			if !ok {
+				child = &flameNode{Name: name}
+				idx[name] = child
+				node.Children = append(node.Children, child)
+				childIndex[child] = map[string]*flameNode{}
+			}
+			child.Value += n
+			node = child
+		}
+	}
+
+	return json.NewEncoder(w).Encode(root)
+}
+
+// flameHTML is a minimal page served at /flame: it fetches /flame.json
+// and renders it with d3-flame-graph, so users get a browser view
+// without needing GraphViz installed locally.
+const flameHTML = `<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>hp flame graph</title>
+<link rel="stylesheet" href="https://cdn.jsdelivr.net/npm/d3-flame-graph@4/dist/d3-flamegraph.css">
+<script src="https://cdn.jsdelivr.net/npm/d3@7"></script>
+<script src="https://cdn.jsdelivr.net/npm/d3-flame-graph@4/dist/d3-flamegraph.min.js"></script>
+</head>
+<body>
+<div id="chart"></div>
+<script>
+var chart = flamegraph().width(window.innerWidth - 40);
+d3.json("/flame.json").then(function(data) {
+	d3.select("#chart").datum(data).call(chart);
+});
+</script>
+</body>
+</html>
+`
@@ -16,6 +16,7 @@ package main
 
 import (
 	"bufio"
+	"bytes"
 	"flag"
 	"fmt"
 	"log"
@@ -29,13 +30,34 @@ import (
 var flag_http *string = flag.String("http", "", "http service address (e.g. ':8000')")
 var flag_profile *bool = flag.Bool("profile", false, "whether to profile hp itself")
 var flag_syms *string = flag.String("syms", "", "load symbols from file instead of binary")
-var flags_builtin_demangle *bool = flag.Bool("builtin-demangler", false, "whether to use built-in linux demangler")
+var flag_demangler *string = flag.String("demangler", "go", "which demangler to use: go|cppfilt|linux|none")
+var flag_interactive *bool = flag.Bool("interactive", false, "drop into an interactive pprof-style command loop instead of writing DOT once")
+var flag_base *string = flag.String("base", "", "baseline profile to diff against; Node.cur/cum then show signed deltas")
+var flag_output *string = flag.String("output", "dot", "output format when writing to stdout: dot|folded|flame")
+
+func init() {
+	flag_sample_index = flag.Int("sample_index", -1, "index into a protobuf profile's sample_type list to use for cur/cum (-1: auto-detect by name)")
+}
 
 type state struct {
 	Profile   *Profile
 	demangler Demangler
 	Graph     *graph
 	Params    *params
+
+	// Diff is set when -base is used: Node.cur/cum and g.edges then hold
+	// signed deltas against the baseline rather than absolute sizes.
+	Diff bool
+
+	// rawStacks and names are the unfiltered inputs to Graph.Analyze,
+	// kept around so interactive focus/ignore/hide/show commands can
+	// re-derive a filtered stack list and rebuild Graph from scratch.
+	rawStacks []*Stack
+	names     map[uint64]string
+
+	// BinaryPath, when non-empty, is the binary loaded for symbols; the
+	// "/source/{addr}" HTTP handler reads its DWARF line info.
+	BinaryPath string
 }
 
 type Node struct {
@@ -119,10 +141,22 @@ func (s *state) Label(n *Node) string {
 func (s *state) SizeLabel(n *Node) string {
 	cur := n.cur.InuseBytes
 	cum := n.cum.InuseBytes
+	if s.Diff {
+		return fmt.Sprintf("%+dk / %+dk", cur/1024, cum/1024)
+	}
 	frac := float32(cum) / float32(s.Profile.Header.InuseBytes)
 	return fmt.Sprintf("%dk of %dk (%.1f%% of total)", cur/1024, cum/1024, frac * 100.0)
 }
 
+// abs returns the absolute value of an int-valued byte/object count.
+// Node.cur/cum and g.edges can be negative in -base diff mode.
+func abs(n int) int {
+	if n < 0 {
+		return -n
+	}
+	return n
+}
+
 func (g *graph) Analyze(stacks []*Stack, names map[uint64]string) {
 	// Accumulate stats into nodes and edges.
 	for _, stack := range stacks {
@@ -149,10 +183,11 @@ func (g *graph) Analyze(stacks []*Stack, names map[uint64]string) {
 		}
 	}
 
-	// Collect node sizes.
+	// Collect node sizes, ranked by magnitude so that -base diff mode
+	// surfaces large reductions (negative cum) alongside large growth.
 	nodeSizes := make([]int, 0, len(g.nodes))
 	for _, n := range g.nodes {
-		size := n.cum.InuseBytes
+		size := abs(n.cum.InuseBytes)
 		if size > 0 {
 			nodeSizes = append(nodeSizes, size)
 		}
@@ -169,7 +204,79 @@ func (g *graph) Analyze(stacks []*Stack, names map[uint64]string) {
 	g.NodeSizes = nodeSizes
 }
 
+// Select returns the set of nodes whose cumulative size ranks in the top
+// keepCount, using the NodeSizes computed by Analyze. GraphViz uses it to
+// keep large graphs readable; the interactive "nodecount" command
+// changes keepCount to widen or narrow the same selection.
+func (g *graph) Select(keepCount int) map[*Node]bool {
+	threshold := 0
+	if keepCount < len(g.NodeSizes) {
+		threshold = g.NodeSizes[keepCount]
+	}
+	kept := make(map[*Node]bool)
+	for _, n := range g.nodes {
+		if abs(n.cum.InuseBytes) >= threshold {
+			kept[n] = true
+		}
+	}
+	return kept
+}
+
+// Rebuild re-runs Analyze over stacks, replacing Graph entirely. Used by
+// the interactive focus/ignore/hide/show commands after they filter
+// rawStacks down to a new stack list.
+func (s *state) Rebuild(stacks []*Stack) {
+	s.Graph = &graph{
+		nodes: make(map[uint64]*Node),
+		edges: make(map[edge]int),
+	}
+	s.Graph.Analyze(stacks, s.names)
+}
+
+// neighborhood returns start plus its ancestors and descendants, reached
+// by BFS over g.edges in both directions, capped at limit nodes total.
+// Used by the "/n/{addr}" HTTP drilldown to focus a large graph around
+// one node.
+func (g *graph) neighborhood(start *Node, limit int) map[*Node]bool {
+	kept := map[*Node]bool{start: true}
+	queue := []*Node{start}
+	for len(queue) > 0 && len(kept) < limit {
+		n := queue[0]
+		queue = queue[1:]
+		for e := range g.edges {
+			var next *Node
+			switch {
+			case e.src == n:
+				next = e.dst
+			case e.dst == n:
+				next = e.src
+			default:
+				continue
+			}
+			if !kept[next] {
+				kept[next] = true
+				queue = append(queue, next)
+				if len(kept) >= limit {
+					break
+				}
+			}
+		}
+	}
+	return kept
+}
+
+// GraphViz writes the full graph as DOT, keeping the top
+// Params.NodeKeepCount nodes by cumulative size.
 func (s *state) GraphViz(w io.Writer) {
+	keptNodes := s.Graph.Select(s.Params.NodeKeepCount)
+	log.Printf("keeping %d of %d nodes (top %d by cumulative size)", len(keptNodes), len(s.Graph.nodes), s.Params.NodeKeepCount)
+	s.renderDOT(w, keptNodes)
+}
+
+// renderDOT writes keptNodes (and the edges between them) as DOT. It's
+// the shared rendering path for GraphViz (top-N by cumulative size) and
+// the "/n/{addr}" HTTP handler (a BFS neighborhood around one node).
+func (s *state) renderDOT(w io.Writer, keptNodes map[*Node]bool) {
 	g := s.Graph
 
 	fmt.Fprintf(w, "digraph G {\n")
@@ -181,19 +288,6 @@ func (s *state) GraphViz(w io.Writer) {
 	fmt.Fprintf(w, "node [fontsize=9]\n")
 	fmt.Fprintf(w, "edge [fontsize=9]\n")
 
-	// Select top N nodes.
-	keptNodes := make(map[*Node]bool)
-	nodeSizeThreshold := 0
-	if s.Params.NodeKeepCount < len(g.NodeSizes) {
-		nodeSizeThreshold = g.NodeSizes[s.Params.NodeKeepCount]
-	}
-	log.Printf("keeping %d nodes with cumulative >= %dk", s.Params.NodeKeepCount, nodeSizeThreshold/1024)
-	for _, n := range g.nodes {
-		if n.cum.InuseBytes >= nodeSizeThreshold {
-			keptNodes[n] = true
-		}
-	}
-
 	// Order edges that reference selected nodes by size.
 	edgelist := make([]interface{}, 0, len(g.edges))
 	for e, _ := range g.edges {
@@ -211,7 +305,7 @@ func (s *state) GraphViz(w io.Writer) {
 
 		if indegree[edge.dst] == 0 {
 			// Keep at least one edge for each dest.
-		} else if size/1024 < 30 {
+		} else if abs(size)/1024 < 30 {
 			continue
 		}
 		outdegree[edge.src]++
@@ -229,7 +323,15 @@ func (s *state) GraphViz(w io.Writer) {
 		}
 		total += n.cur.InuseBytes
 		label := s.Label(n) + "\\n" + s.SizeLabel(n)
-		fmt.Fprintf(w, "%d [label=\"%s\",shape=box,href=\"%d\"]\n", n.addr, label, n.addr)
+		color := ""
+		if s.Diff {
+			if n.cum.InuseBytes > 0 {
+				color = ",color=red,fontcolor=red"
+			} else if n.cum.InuseBytes < 0 {
+				color = ",color=darkgreen,fontcolor=darkgreen"
+			}
+		}
+		fmt.Fprintf(w, "%d [label=\"%s\",shape=box,href=\"/n/%d\"%s]\n", n.addr, label, n.addr, color)
 	}
 	log.Printf("total not shown: %dk", missing/1024.0)
 	log.Printf("total kept nodes: %dk", total/1024.0)
@@ -254,6 +356,11 @@ func main() {
 	var symsPath, binaryPath, profilePath string
 	if len(*flag_syms) > 0 {
 		symsPath, profilePath = *flag_syms, flag.Arg(0)
+	} else if isHTTPURL(flag.Arg(0)) {
+		// "hp http://host:6060/debug/pprof/heap": no local binary, the
+		// protobuf profile carries its own symbol table (with a
+		// /debug/pprof/symbol fallback for any names it's missing).
+		profilePath = flag.Arg(0)
 	} else {
 		binaryPath, profilePath = flag.Arg(0), flag.Arg(1)
 	}
@@ -270,12 +377,24 @@ func main() {
 			profChan <- nil
 			return
 		}
-		log.Printf("reading profile from %s", profilePath)
-		f, err := os.Open(profilePath)
-		check(err)
-		profile := ParseHeap(bufio.NewReader(f))
-		f.Close()
+		var data []byte
+		var contentType string
+		if isHTTPURL(profilePath) {
+			log.Printf("fetching profile from %s", profilePath)
+			data, contentType = FetchProfile(profilePath)
+		} else {
+			log.Printf("reading profile from %s", profilePath)
+			f, err := os.Open(profilePath)
+			check(err)
+			data, err = io.ReadAll(f)
+			check(err)
+			f.Close()
+		}
+		profile := Parse(bufio.NewReader(bytes.NewReader(data)))
 		log.Printf("loaded %d stacks", len(profile.stacks))
+		if contentType == "application/vnd.google.protobuf" {
+			resolveMissingSymbols(profile, profilePath)
+		}
 		profChan <- profile
 	}()
 
@@ -287,7 +406,7 @@ func main() {
 			log.Printf("loaded %d syms", len(syms))
 			symChan <- syms
 		}()
-	} else {
+	} else if len(symsPath) > 0 {
 		go func() {
 			if noLoad {
 				symChan <- nil
@@ -298,26 +417,63 @@ func main() {
 			log.Printf("loaded %d syms", len(syms))
 			symChan <- syms
 		}()
+	} else {
+		// No binary or -syms given: rely entirely on the profile's own
+		// symbol table (protobuf profiles carry one; see FetchProfile).
+		go func() { symChan <- nil }()
 	}
 
 	syms := <-symChan
 	profile := <-profChan
 
 	state := &state{
-		Profile: profile,
+		Profile:    profile,
+		BinaryPath: binaryPath,
 	}
-	if *flags_builtin_demangle {
-		state.demangler = NewLinuxDemangler(false)
-	} else {
+	switch *flag_demangler {
+	case "cppfilt":
 		state.demangler = NewCppFilt()
+	case "linux":
+		state.demangler = NewLinuxDemangler(false)
+	case "none":
+		state.demangler = NewNoopDemangler()
+	case "go":
+		state.demangler = NewGoDemangler()
+	default:
+		log.Fatalf("unknown -demangler %q (want go|cppfilt|linux|none)", *flag_demangler)
 	}
 
 	var names map[uint64]string
 	if noLoad {
 		syms = syms
+	} else if profile.names != nil {
+		// Protobuf profiles carry their own symbol table; no need to
+		// resolve addresses against an external binary/symbol map.
+		names = profile.names
 	} else {
 		names = CleanupStacks(state.Profile.stacks, syms)
 	}
+	profile.names = names
+
+	if len(*flag_base) > 0 {
+		log.Printf("reading baseline profile from %s", *flag_base)
+		bf, err := os.Open(*flag_base)
+		check(err)
+		baseProfile := Parse(bufio.NewReader(bf))
+		bf.Close()
+		log.Printf("loaded %d baseline stacks", len(baseProfile.stacks))
+
+		if baseProfile.names == nil {
+			baseProfile.names = CleanupStacks(baseProfile.stacks, syms)
+		}
+
+		profile = DiffProfiles(baseProfile, profile)
+		state.Profile = profile
+		names = profile.names
+		state.Diff = true
+	}
+	state.names = names
+	state.rawStacks = profile.stacks
 
 	state.Graph = &graph{
 		nodes: make(map[uint64]*Node),
@@ -328,12 +484,21 @@ func main() {
 		NodeKeepCount: 100,
 	}
 
-	if len(*flag_http) > 0 {
+	if *flag_interactive {
+		state.Interactive()
+	} else if len(*flag_http) > 0 {
 		log.Printf("serving on %s", *flag_http)
 		state.ServeHttp(*flag_http)
 	} else {
 		log.Printf("writing output...")
-		state.GraphViz(os.Stdout)
+		switch *flag_output {
+		case "folded":
+			state.Folded(os.Stdout)
+		case "flame":
+			check(state.FlameJSON(os.Stdout))
+		default:
+			state.GraphViz(os.Stdout)
+		}
 	}
 
 	log.Printf("done")
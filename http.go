@@ -0,0 +1,230 @@
+// Copyright 2011 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"debug/dwarf"
+	"debug/elf"
+	"fmt"
+	"html"
+	"io"
+	"log"
+	"net/http"
+	"net/http/pprof"
+	"os"
+	"os/exec"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// ServeHttp serves the graph over HTTP at addr: "/" renders the full
+// graph as inline SVG, "/n/<addr>" focuses it on one node's neighbors,
+// "/top" lists nodes by cumulative size, "/source/<addr>" shows nearby
+// source lines, and "/flame"/"/flame.json" give a browser flame-graph
+// view. "/debug/pprof/" profiles hp itself while it serves large graphs.
+func (s *state) ServeHttp(addr string) {
+	mux := http.NewServeMux()
+
+	mux.HandleFunc("/", s.handleGraph)
+	mux.HandleFunc("/n/", s.handleNode)
+	mux.HandleFunc("/top", s.handleTop)
+	mux.HandleFunc("/source/", s.handleSource)
+
+	mux.HandleFunc("/flame", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html")
+		io.WriteString(w, flameHTML)
+	})
+	mux.HandleFunc("/flame.json", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		if err := s.FlameJSON(w); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		}
+	})
+
+	mux.HandleFunc("/debug/pprof/", pprof.Index)
+	mux.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
+	mux.HandleFunc("/debug/pprof/profile", pprof.Profile)
+	mux.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+	mux.HandleFunc("/debug/pprof/trace", pprof.Trace)
+
+	log.Fatal(http.ListenAndServe(addr, mux))
+}
+
+// renderSVG writes keptNodes as SVG by shelling out to "dot -Tsvg"; if
+// dot isn't installed, it falls back to serving the raw DOT source so
+// the response is still useful.
+func (s *state) renderSVG(w http.ResponseWriter, keptNodes map[*Node]bool) {
+	var dot bytes.Buffer
+	s.renderDOT(&dot, keptNodes)
+
+	cmd := exec.Command("dot", "-Tsvg")
+	cmd.Stdin = bytes.NewReader(dot.Bytes())
+	var svg bytes.Buffer
+	cmd.Stdout = &svg
+	if err := cmd.Run(); err != nil {
+		log.Printf("dot -Tsvg failed (%v); serving raw DOT instead", err)
+		w.Header().Set("Content-Type", "text/plain")
+		w.Write(dot.Bytes())
+		return
+	}
+	w.Header().Set("Content-Type", "image/svg+xml")
+	w.Write(svg.Bytes())
+}
+
+func (s *state) handleGraph(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path != "/" {
+		http.NotFound(w, r)
+		return
+	}
+	s.renderSVG(w, s.Graph.Select(s.Params.NodeKeepCount))
+}
+
+// handleNode serves /n/<addr>: the graph BFS-restricted to addr's
+// ancestors and descendants, capped by Params.NodeKeepCount.
+func (s *state) handleNode(w http.ResponseWriter, r *http.Request) {
+	addr, err := strconv.ParseUint(strings.TrimPrefix(r.URL.Path, "/n/"), 0, 64)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("bad address: %v", err), http.StatusBadRequest)
+		return
+	}
+	n := s.Graph.nodes[addr]
+	if n == nil {
+		http.NotFound(w, r)
+		return
+	}
+	s.renderSVG(w, s.Graph.neighborhood(n, s.Params.NodeKeepCount))
+}
+
+// handleTop serves /top: an HTML table of every node sorted by
+// cum.InuseBytes, linking back into /n/<addr>.
+func (s *state) handleTop(w http.ResponseWriter, r *http.Request) {
+	nodes := make([]*Node, 0, len(s.Graph.nodes))
+	for _, n := range s.Graph.nodes {
+		nodes = append(nodes, n)
+	}
+	sort.Slice(nodes, func(i, j int) bool { return nodes[i].cum.InuseBytes > nodes[j].cum.InuseBytes })
+
+	w.Header().Set("Content-Type", "text/html")
+	fmt.Fprint(w, "<html><body><table border=\"1\">\n")
+	fmt.Fprint(w, "<tr><th>cum</th><th>cur</th><th>name</th></tr>\n")
+	for _, n := range nodes {
+		fmt.Fprintf(w, "<tr><td>%dk</td><td>%dk</td><td><a href=\"/n/%d\">%s</a></td></tr>\n",
+			n.cum.InuseBytes/1024, n.cur.InuseBytes/1024, n.addr, html.EscapeString(s.Label(n)))
+	}
+	fmt.Fprint(w, "</table></body></html>\n")
+}
+
+// handleSource serves /source/<addr>: a few source lines around addr's
+// DWARF line-table entry, read from BinaryPath.
+func (s *state) handleSource(w http.ResponseWriter, r *http.Request) {
+	addr, err := strconv.ParseUint(strings.TrimPrefix(r.URL.Path, "/source/"), 0, 64)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("bad address: %v", err), http.StatusBadRequest)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/plain")
+	lines, err := s.sourceLines(addr)
+	if err != nil {
+		fmt.Fprintf(w, "source unavailable for 0x%x: %v\n", addr, err)
+		return
+	}
+	for _, line := range lines {
+		fmt.Fprintln(w, line)
+	}
+}
+
+// sourceLines looks up addr's nearest DWARF line-table entry in
+// BinaryPath (the binary loaded for symbols, if any) and returns a few
+// lines of source around it, addr2line-style.
+func (s *state) sourceLines(addr uint64) ([]string, error) {
+	if len(s.BinaryPath) == 0 {
+		return nil, fmt.Errorf("no binary loaded (pass one on the command line)")
+	}
+	ef, err := elf.Open(s.BinaryPath)
+	if err != nil {
+		return nil, err
+	}
+	defer ef.Close()
+	d, err := ef.DWARF()
+	if err != nil {
+		return nil, err
+	}
+
+	var bestAddr uint64
+	var bestFile string
+	var bestLine int
+
+	r := d.Reader()
+	for {
+		entry, err := r.Next()
+		if err != nil {
+			return nil, err
+		}
+		if entry == nil {
+			break
+		}
+		if entry.Tag != dwarf.TagCompileUnit {
+			continue
+		}
+		lr, err := d.LineReader(entry)
+		if err != nil || lr == nil {
+			continue
+		}
+		var le dwarf.LineEntry
+		for lr.Next(&le) == nil {
+			if le.Address <= addr && le.Address >= bestAddr {
+				bestAddr, bestFile, bestLine = le.Address, le.File.Name, le.Line
+			}
+		}
+	}
+
+	if bestFile == "" {
+		return nil, fmt.Errorf("no line info for 0x%x", addr)
+	}
+	return sourceAround(bestFile, bestLine, 5)
+}
+
+// sourceAround reads the lines [line-context, line+context] of path,
+// marking line itself with "->".
+func sourceAround(path string, line, context int) ([]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var out []string
+	n := 0
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		n++
+		if n < line-context {
+			continue
+		}
+		if n > line+context {
+			break
+		}
+		marker := "  "
+		if n == line {
+			marker = "->"
+		}
+		out = append(out, fmt.Sprintf("%s %5d  %s", marker, n, scanner.Text()))
+	}
+	return out, scanner.Err()
+}
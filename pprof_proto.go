@@ -0,0 +1,380 @@
+// Copyright 2011 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bufio"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// sampleIndex selects which value of a multi-valued pprof sample (e.g.
+// inuse_space vs inuse_objects vs alloc_space) drives Stats.InuseBytes.
+// -1 means "pick inuse_space/alloc_space by name if present, else 0".
+var flag_sample_index *int
+
+// Parse reads a profile, auto-detecting its format: the modern gzipped
+// protobuf profile.proto format used by runtime/pprof, jemalloc and Linux
+// perf, or (if the gzip magic is absent) the legacy text heap format.
+func Parse(r *bufio.Reader) *Profile {
+	magic, err := r.Peek(2)
+	if err == nil && len(magic) == 2 && magic[0] == 0x1f && magic[1] == 0x8b {
+		gz, err := gzip.NewReader(r)
+		check(err)
+		data, err := io.ReadAll(gz)
+		check(err)
+		return ParseProto(data)
+	}
+	return ParseHeap(r)
+}
+
+// ParseProto decodes a serialized pprof profile.proto Profile message
+// into a Profile, using sample_type to pick which value index drives
+// cur/cum (see -sample_index).
+func ParseProto(data []byte) *Profile {
+	msg := pbParse(data)
+
+	var strings_ []string
+	var sampleTypes [][2]int64 // (type, unit) string-table indices
+	var samples []pbSample
+	var mappings []pbMapping
+	var locations []pbLocation
+	var functions = map[uint64]pbFunction{}
+
+	for _, f := range msg {
+		switch f.num {
+		case 1: // sample_type
+			st := pbParse(f.data)
+			var typ, unit int64
+			for _, sf := range st {
+				switch sf.num {
+				case 1:
+					typ = int64(sf.varint)
+				case 2:
+					unit = int64(sf.varint)
+				}
+			}
+			sampleTypes = append(sampleTypes, [2]int64{typ, unit})
+		case 2: // sample
+			samples = append(samples, parsePbSample(f.data))
+		case 3: // mapping
+			mappings = append(mappings, parsePbMapping(f.data))
+		case 4: // location
+			locations = append(locations, parsePbLocation(f.data))
+		case 5: // function
+			fn := parsePbFunction(f.data)
+			functions[fn.id] = fn
+		case 6: // string_table
+			strings_ = append(strings_, string(f.data))
+		}
+	}
+
+	str := func(i int64) string {
+		if i >= 0 && int(i) < len(strings_) {
+			return strings_[i]
+		}
+		return ""
+	}
+
+	idx := pickSampleIndex(sampleTypes, str)
+	objIdx := pickObjectsIndex(sampleTypes, str, idx)
+
+	locByID := make(map[uint64]pbLocation, len(locations))
+	for _, l := range locations {
+		locByID[l.id] = l
+	}
+
+	p := &Profile{names: make(map[uint64]string)}
+	for _, m := range mappings {
+		p.maps = append(p.maps, &MapEntry{
+			lo:     m.start,
+			hi:     m.limit,
+			offset: m.offset,
+			path:   str(m.filename),
+		})
+	}
+
+	// The location id, not Location.address, is used as the node/stack
+	// address throughout hp, since it's dense and small; Function.name
+	// -- already demangled -- becomes the node's label directly,
+	// bypassing the c++filt/demangler pass entirely for protobuf-sourced
+	// profiles. The real address is kept in locAddrs for the one thing
+	// that needs an actual PC: live symbol resolution (see fetch.go).
+	for _, loc := range locations {
+		if loc.address != 0 {
+			if p.locAddrs == nil {
+				p.locAddrs = make(map[uint64]uint64)
+			}
+			p.locAddrs[loc.id] = loc.address
+		}
+		if len(loc.lines) == 0 {
+			continue
+		}
+		if fn, ok := functions[loc.lines[0].functionID]; ok {
+			p.names[loc.id] = str(fn.name)
+		}
+	}
+
+	for _, s := range samples {
+		var value int
+		if idx < len(s.value) {
+			value = int(s.value[idx])
+		}
+		objects := 1
+		if objIdx >= 0 && objIdx < len(s.value) {
+			objects = int(s.value[objIdx])
+		}
+		stats := Stats{InuseBytes: value, InuseObjects: objects}
+
+		// profile.proto documents location_id[0] as the leaf frame, the
+		// same leaf-first order graph.Analyze assumes for Stack.Stack
+		// (it attributes cur, direct self size, to Stack[0]) -- so copy
+		// as-is rather than reversing.
+		addrs := append([]uint64(nil), s.locationIDs...)
+
+		p.stacks = append(p.stacks, &Stack{Stack: addrs, Stats: stats})
+		p.Header.Add(stats)
+	}
+
+	return p
+}
+
+// pickSampleIndex chooses which sample value column to use, preferring
+// inuse_space over alloc_space (matching the legacy heap Stats
+// semantics, where InuseBytes is the currently-resident size, not the
+// cumulative-ever-allocated size), honoring -sample_index when set.
+// Go's runtime/pprof heap profiles list alloc_space before inuse_space,
+// so this must search by priority across all columns rather than
+// returning the first column whose name is merely in the candidate set.
+func pickSampleIndex(types [][2]int64, str func(int64) string) int {
+	if flag_sample_index != nil && *flag_sample_index >= 0 {
+		return *flag_sample_index
+	}
+	for _, name := range []string{"inuse_space", "alloc_space", "cpu", "nanoseconds"} {
+		if i := indexOfSampleType(types, str, name); i >= 0 {
+			return i
+		}
+	}
+	return 0
+}
+
+// pickObjectsIndex chooses which sample value column holds an object
+// count, paired with whichever family -- inuse_* or alloc_* -- the
+// bytes column (bytesIdx, as chosen by pickSampleIndex) belongs to, so
+// an explicit -sample_index forcing alloc_space doesn't get paired
+// with a resident inuse_objects count. Returns -1 if neither is
+// present, e.g. a CPU profile.
+func pickObjectsIndex(types [][2]int64, str func(int64) string, bytesIdx int) int {
+	order := []string{"inuse_objects", "alloc_objects"}
+	if bytesIdx >= 0 && bytesIdx < len(types) && strings.HasPrefix(str(types[bytesIdx][0]), "alloc") {
+		order = []string{"alloc_objects", "inuse_objects"}
+	}
+	for _, name := range order {
+		if i := indexOfSampleType(types, str, name); i >= 0 {
+			return i
+		}
+	}
+	return -1
+}
+
+// indexOfSampleType returns the index of the sample_type column named
+// name, or -1 if types has none.
+func indexOfSampleType(types [][2]int64, str func(int64) string, name string) int {
+	for i, t := range types {
+		if str(t[0]) == name {
+			return i
+		}
+	}
+	return -1
+}
+
+// --- minimal protobuf wire-format decoding ---
+
+type pbField struct {
+	num    int
+	wire   int
+	varint uint64
+	data   []byte
+}
+
+func pbParse(data []byte) []pbField {
+	var fields []pbField
+	for len(data) > 0 {
+		tag, n := pbVarint(data)
+		if n == 0 {
+			break
+		}
+		data = data[n:]
+		num := int(tag >> 3)
+		wire := int(tag & 0x7)
+
+		switch wire {
+		case 0: // varint
+			v, n := pbVarint(data)
+			data = data[n:]
+			fields = append(fields, pbField{num: num, wire: wire, varint: v})
+		case 1: // 64-bit
+			fields = append(fields, pbField{num: num, wire: wire, data: data[:8]})
+			data = data[8:]
+		case 2: // length-delimited
+			l, n := pbVarint(data)
+			data = data[n:]
+			fields = append(fields, pbField{num: num, wire: wire, data: data[:l]})
+			data = data[l:]
+		case 5: // 32-bit
+			fields = append(fields, pbField{num: num, wire: wire, data: data[:4]})
+			data = data[4:]
+		default:
+			panic(fmt.Sprintf("unsupported protobuf wire type %d", wire))
+		}
+	}
+	return fields
+}
+
+func pbVarint(data []byte) (uint64, int) {
+	var v uint64
+	for i := 0; i < len(data); i++ {
+		b := data[i]
+		v |= uint64(b&0x7f) << uint(7*i)
+		if b&0x80 == 0 {
+			return v, i + 1
+		}
+	}
+	return 0, 0
+}
+
+type pbSample struct {
+	locationIDs []uint64
+	value       []int64
+}
+
+func parsePbSample(data []byte) pbSample {
+	var s pbSample
+	for _, f := range pbParse(data) {
+		switch f.num {
+		case 1:
+			s.locationIDs = append(s.locationIDs, pbRepeatedVarint(f)...)
+		case 2:
+			for _, v := range pbRepeatedVarint(f) {
+				s.value = append(s.value, int64(v))
+			}
+		}
+	}
+	return s
+}
+
+// pbRepeatedVarint returns the values of a repeated scalar field, which
+// proto3 encodes packed by default: a single wire-type-2 field holding
+// concatenated varints, rather than one wire-type-0 field per value (the
+// older, still-legal "unpacked" form). f.wire tells us which form this
+// particular field used.
+func pbRepeatedVarint(f pbField) []uint64 {
+	if f.wire != 2 {
+		return []uint64{f.varint}
+	}
+	var out []uint64
+	data := f.data
+	for len(data) > 0 {
+		v, n := pbVarint(data)
+		if n == 0 {
+			break
+		}
+		out = append(out, v)
+		data = data[n:]
+	}
+	return out
+}
+
+type pbMapping struct {
+	id       uint64
+	start    uint64
+	limit    uint64
+	offset   uint64
+	filename int64
+}
+
+func parsePbMapping(data []byte) pbMapping {
+	var m pbMapping
+	for _, f := range pbParse(data) {
+		switch f.num {
+		case 1:
+			m.id = f.varint
+		case 2:
+			m.start = f.varint
+		case 3:
+			m.limit = f.varint
+		case 4:
+			m.offset = f.varint
+		case 5:
+			m.filename = int64(f.varint)
+		}
+	}
+	return m
+}
+
+type pbLine struct {
+	functionID uint64
+	line       int64
+}
+
+type pbLocation struct {
+	id      uint64
+	address uint64
+	lines   []pbLine
+}
+
+func parsePbLocation(data []byte) pbLocation {
+	var l pbLocation
+	for _, f := range pbParse(data) {
+		switch f.num {
+		case 1:
+			l.id = f.varint
+		case 3:
+			l.address = f.varint
+		case 4:
+			var line pbLine
+			for _, lf := range pbParse(f.data) {
+				switch lf.num {
+				case 1:
+					line.functionID = lf.varint
+				case 2:
+					line.line = int64(lf.varint)
+				}
+			}
+			l.lines = append(l.lines, line)
+		}
+	}
+	return l
+}
+
+type pbFunction struct {
+	id   uint64
+	name int64
+}
+
+func parsePbFunction(data []byte) pbFunction {
+	var fn pbFunction
+	for _, f := range pbParse(data) {
+		switch f.num {
+		case 1:
+			fn.id = f.varint
+		case 2:
+			fn.name = int64(f.varint)
+		}
+	}
+	return fn
+}
@@ -0,0 +1,208 @@
+// Copyright 2011 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Stats holds the counts reported for a stack sample: how many bytes and
+// objects it accounts for while in use, and cumulatively over the life of
+// the process.
+type Stats struct {
+	InuseBytes   int
+	InuseObjects int
+	AllocBytes   int
+	AllocObjects int
+}
+
+func (s *Stats) Add(o Stats) {
+	s.InuseBytes += o.InuseBytes
+	s.InuseObjects += o.InuseObjects
+	s.AllocBytes += o.AllocBytes
+	s.AllocObjects += o.AllocObjects
+}
+
+// Stack is a single sampled call stack together with the stats it
+// contributed.
+type Stack struct {
+	Stack []uint64
+	Stats Stats
+}
+
+// MapEntry describes one line of a /proc/self/maps-style mapping: the
+// address range a binary or shared library was loaded at.
+type MapEntry struct {
+	lo, hi uint64
+	offset uint64
+	path   string
+}
+
+// Maps is a collection of MapEntry sorted by lo, searchable by address.
+type Maps []*MapEntry
+
+// Search returns the MapEntry containing addr, or nil if none matches.
+func (m Maps) Search(addr uint64) *MapEntry {
+	for _, e := range m {
+		if addr >= e.lo && addr < e.hi {
+			return e
+		}
+	}
+	return nil
+}
+
+// Profile is a parsed heap (or other) profile: the stacks that were
+// sampled, the mappings active at the time, and a Header summarizing the
+// profile as a whole (used e.g. to compute percentages of total).
+type Profile struct {
+	Header Stats
+	stacks []*Stack
+	maps   Maps
+
+	// names, when non-nil, gives symbol names for stack addresses
+	// directly from the profile itself (e.g. a protobuf profile's
+	// Function table), bypassing binary/symbol-map lookup.
+	names map[uint64]string
+
+	// locAddrs maps a protobuf profile's synthetic per-stack addresses
+	// (Location.id, used elsewhere as the node address) to the real PC
+	// address the profile reported (Location.address), when known and
+	// nonzero. It's consulted only by FetchSymbols/resolveMissingSymbols,
+	// which need a real address to query a live /debug/pprof/symbol
+	// endpoint; nil for legacy text heap profiles, whose stack addresses
+	// already are real addresses.
+	locAddrs map[uint64]uint64
+}
+
+// ParseHeap parses the legacy Google-style text heap profile format
+// produced by tcmalloc / gperftools, e.g.:
+//
+//	heap profile:     12:      345 [    12:      345] @ heap/1048576
+//	     3:      128 [     3:      128] @ 0x400123 0x400456
+//	MAPPED_LIBRARIES:
+//	00400000-00401000 r-xp 00000000 08:01 1234 /path/to/binary
+func ParseHeap(r *bufio.Reader) *Profile {
+	p := &Profile{}
+
+	for {
+		line, err := r.ReadString('\n')
+		line = strings.TrimSpace(line)
+		if line == "MAPPED_LIBRARIES:" {
+			break
+		}
+		if len(line) > 0 {
+			if stack, header, ok := parseHeapLine(line); ok {
+				if header {
+					p.Header = stack.Stats
+				} else {
+					p.stacks = append(p.stacks, stack)
+				}
+			}
+		}
+		if err != nil {
+			return p
+		}
+	}
+
+	for {
+		line, err := r.ReadString('\n')
+		line = strings.TrimSpace(line)
+		if len(line) > 0 {
+			if e := parseMapLine(line); e != nil {
+				p.maps = append(p.maps, e)
+			}
+		}
+		if err != nil {
+			break
+		}
+	}
+
+	return p
+}
+
+// parseHeapLine parses one "n: bytes [n: bytes] @ addr addr ..." sample
+// line (or the "heap profile: ..." header line, which has the same
+// shape). header reports whether this was the header line.
+func parseHeapLine(line string) (stack *Stack, header bool, ok bool) {
+	header = strings.HasPrefix(line, "heap profile:")
+	line = strings.TrimPrefix(line, "heap profile:")
+
+	at := strings.Index(line, "@")
+	if at < 0 {
+		return nil, false, false
+	}
+	counts, addrs := line[:at], line[at+1:]
+
+	counts = strings.Trim(counts, " []")
+	parts := strings.Fields(strings.NewReplacer("[", " ", "]", " ", ":", " ").Replace(counts))
+	if len(parts) < 4 {
+		return nil, false, false
+	}
+	var stats Stats
+	stats.InuseObjects, _ = strconv.Atoi(parts[0])
+	stats.InuseBytes, _ = strconv.Atoi(parts[1])
+	stats.AllocObjects, _ = strconv.Atoi(parts[2])
+	stats.AllocBytes, _ = strconv.Atoi(parts[3])
+
+	var addrList []uint64
+	for _, f := range strings.Fields(addrs) {
+		if strings.HasPrefix(f, "heap/") {
+			continue
+		}
+		f = strings.TrimPrefix(f, "0x")
+		a, err := strconv.ParseUint(f, 16, 64)
+		if err == nil {
+			addrList = append(addrList, a)
+		}
+	}
+
+	return &Stack{Stack: addrList, Stats: stats}, header, true
+}
+
+// parseMapLine parses one line of the MAPPED_LIBRARIES section, in the
+// same format as /proc/self/maps.
+func parseMapLine(line string) *MapEntry {
+	fields := strings.Fields(line)
+	if len(fields) < 5 {
+		return nil
+	}
+	addrRange := strings.SplitN(fields[0], "-", 2)
+	if len(addrRange) != 2 {
+		return nil
+	}
+	lo, err1 := strconv.ParseUint(addrRange[0], 16, 64)
+	hi, err2 := strconv.ParseUint(addrRange[1], 16, 64)
+	if err1 != nil || err2 != nil {
+		return nil
+	}
+	offset, _ := strconv.ParseUint(fields[2], 16, 64)
+	path := ""
+	if len(fields) >= 6 {
+		path = fields[5]
+	}
+	return &MapEntry{lo: lo, hi: hi, offset: offset, path: path}
+}
+
+// check panics if err is non-nil. hp is a small command-line tool, so
+// fatal errors from I/O are reported by letting the program crash with a
+// stack trace rather than threading error returns everywhere.
+func check(err error) {
+	if err != nil {
+		panic(fmt.Sprintf("%v", err))
+	}
+}
@@ -0,0 +1,271 @@
+// Copyright 2011 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"os/exec"
+	"regexp"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// filterState holds the active focus/ignore/hide/show regexes, mirroring
+// google/pprof's interactive driver: focus/ignore keep or drop whole
+// stacks that mention a matching symbol; hide/show drop or keep
+// individual frames within a surviving stack.
+type filterState struct {
+	focus, ignore, hide, show *regexp.Regexp
+}
+
+// Interactive drops into a pprof-style command loop over stdin instead
+// of writing DOT once. Type "help" for the command list.
+func (s *state) Interactive() {
+	filter := &filterState{}
+	scanner := bufio.NewScanner(os.Stdin)
+	fmt.Print("(hp) ")
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if len(line) > 0 {
+			s.runCommand(line, filter)
+		}
+		fmt.Print("(hp) ")
+	}
+}
+
+func (s *state) runCommand(line string, filter *filterState) {
+	fields := strings.Fields(line)
+	cmd, args := fields[0], fields[1:]
+
+	compile := func() (*regexp.Regexp, bool) {
+		if len(args) != 1 {
+			fmt.Printf("usage: %s <regex>\n", cmd)
+			return nil, false
+		}
+		re, err := regexp.Compile(args[0])
+		if err != nil {
+			fmt.Printf("bad regex: %v\n", err)
+			return nil, false
+		}
+		return re, true
+	}
+
+	switch cmd {
+	case "top":
+		n := 10
+		if len(args) > 0 {
+			if v, err := strconv.Atoi(args[0]); err == nil {
+				n = v
+			}
+		}
+		s.cmdTop(n)
+	case "focus", "ignore", "hide", "show":
+		re, ok := compile()
+		if !ok {
+			return
+		}
+		switch cmd {
+		case "focus":
+			filter.focus = re
+		case "ignore":
+			filter.ignore = re
+		case "hide":
+			filter.hide = re
+		case "show":
+			filter.show = re
+		}
+		s.Rebuild(s.applyFilters(filter))
+	case "peek":
+		if re, ok := compile(); ok {
+			s.cmdPeek(re)
+		}
+	case "list":
+		if re, ok := compile(); ok {
+			s.cmdList(re)
+		}
+	case "web":
+		s.cmdWeb()
+	case "nodecount":
+		if len(args) != 1 {
+			fmt.Println("usage: nodecount <N>")
+			return
+		}
+		n, err := strconv.Atoi(args[0])
+		if err != nil {
+			fmt.Printf("bad count: %v\n", err)
+			return
+		}
+		s.Params.NodeKeepCount = n
+	case "help":
+		printHelp()
+	case "quit", "exit":
+		os.Exit(0)
+	default:
+		fmt.Printf("unknown command %q; type help for a list\n", cmd)
+	}
+}
+
+// applyFilters re-derives the stack list to analyze from s.rawStacks
+// according to f.
+func (s *state) applyFilters(f *filterState) []*Stack {
+	matches := func(re *regexp.Regexp, stack *Stack) bool {
+		if re == nil {
+			return false
+		}
+		for _, addr := range stack.Stack {
+			if re.MatchString(s.names[addr]) {
+				return true
+			}
+		}
+		return false
+	}
+
+	var out []*Stack
+	for _, stack := range s.rawStacks {
+		if f.focus != nil && !matches(f.focus, stack) {
+			continue
+		}
+		if f.ignore != nil && matches(f.ignore, stack) {
+			continue
+		}
+
+		addrs := stack.Stack
+		if f.hide != nil || f.show != nil {
+			var kept []uint64
+			for _, addr := range addrs {
+				name := s.names[addr]
+				if f.hide != nil && f.hide.MatchString(name) {
+					continue
+				}
+				if f.show != nil && !f.show.MatchString(name) {
+					continue
+				}
+				kept = append(kept, addr)
+			}
+			addrs = kept
+		}
+
+		out = append(out, &Stack{Stack: addrs, Stats: stack.Stats})
+	}
+	return out
+}
+
+// cmdTop prints the n largest nodes by cumulative size, as SizeLabel/Label.
+func (s *state) cmdTop(n int) {
+	nodes := make([]*Node, 0, len(s.Graph.nodes))
+	for _, node := range s.Graph.nodes {
+		nodes = append(nodes, node)
+	}
+	sort.Slice(nodes, func(i, j int) bool {
+		return nodes[i].cum.InuseBytes > nodes[j].cum.InuseBytes
+	})
+	if n > len(nodes) {
+		n = len(nodes)
+	}
+	for _, node := range nodes[:n] {
+		fmt.Printf("%s  %s\n", s.SizeLabel(node), s.Label(node))
+	}
+}
+
+// cmdPeek prints the callers and callees of nodes matching re, with edge
+// weights from g.edges, mirroring pprof's "peek".
+func (s *state) cmdPeek(re *regexp.Regexp) {
+	g := s.Graph
+	found := false
+	for _, n := range g.nodes {
+		if !re.MatchString(s.Label(n)) {
+			continue
+		}
+		found = true
+		fmt.Println(strings.Repeat("-", 60))
+		for e, weight := range g.edges {
+			if e.dst == n {
+				fmt.Printf("  %8dk  <- %s\n", weight/1024, s.Label(e.src))
+			}
+		}
+		fmt.Printf("%s  %s\n", s.SizeLabel(n), s.Label(n))
+		for e, weight := range g.edges {
+			if e.src == n {
+				fmt.Printf("  %8dk  -> %s\n", weight/1024, s.Label(e.dst))
+			}
+		}
+	}
+	if !found {
+		fmt.Printf("no nodes matching %q\n", re)
+	}
+}
+
+// cmdList prints source lines for nodes matching re, using DWARF line
+// info from the binary loaded on the command line (see sourceLines).
+func (s *state) cmdList(re *regexp.Regexp) {
+	found := false
+	for _, n := range s.Graph.nodes {
+		if re.MatchString(s.Label(n)) {
+			found = true
+			fmt.Printf("%s  0x%x\n", s.Label(n), n.addr)
+			lines, err := s.sourceLines(n.addr)
+			if err != nil {
+				fmt.Printf("  source unavailable: %v\n", err)
+				continue
+			}
+			for _, line := range lines {
+				fmt.Printf("  %s\n", line)
+			}
+		}
+	}
+	if !found {
+		fmt.Printf("no nodes matching %q\n", re)
+	}
+}
+
+// cmdWeb writes the current graph as DOT to a temp file and opens it
+// with the platform's default handler, the same workflow pprof's "web"
+// command uses.
+func (s *state) cmdWeb() {
+	f, err := os.CreateTemp("", "hp-*.dot")
+	if err != nil {
+		fmt.Printf("web: %v\n", err)
+		return
+	}
+	defer f.Close()
+	s.GraphViz(f)
+
+	opener := "xdg-open"
+	if runtime.GOOS == "darwin" {
+		opener = "open"
+	}
+	if err := exec.Command(opener, f.Name()).Start(); err != nil {
+		fmt.Printf("web: %v (dot file left at %s)\n", err, f.Name())
+	}
+}
+
+func printHelp() {
+	fmt.Print(`commands:
+  top [N]          show the N largest nodes by cumulative size (default 10)
+  focus <regex>    keep only stacks mentioning a matching symbol
+  ignore <regex>   drop stacks mentioning a matching symbol
+  hide <regex>     drop matching frames from surviving stacks
+  show <regex>     keep only matching frames in surviving stacks
+  peek <regex>     show callers/callees of matching nodes
+  list <regex>     show source lines for matching nodes (if available)
+  web              open the current graph with the system DOT viewer
+  nodecount N      set how many nodes GraphViz/top keep
+  quit             exit
+`)
+}
@@ -0,0 +1,92 @@
+// Copyright 2011 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bufio"
+	"log"
+	"os"
+	"os/exec"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Sym is one entry of a symbol table: the address it starts at and its
+// (possibly mangled) name.
+type Sym struct {
+	addr uint64
+	name string
+}
+
+// Symbols is a symbol table sorted by address, searchable by Lookup.
+type Symbols []*Sym
+
+// Lookup returns the symbol covering addr: the symbol with the largest
+// address not greater than addr.
+func (s Symbols) Lookup(addr uint64) *Sym {
+	i := sort.Search(len(s), func(i int) bool { return s[i].addr > addr }) - 1
+	if i < 0 {
+		return nil
+	}
+	return s[i]
+}
+
+// LoadSyms loads a symbol table from a binary by shelling out to nm.
+func LoadSyms(path string) Symbols {
+	out, err := exec.Command("nm", "-n", path).Output()
+	check(err)
+
+	var syms Symbols
+	for _, line := range strings.Split(string(out), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) < 3 {
+			continue
+		}
+		addr, err := strconv.ParseUint(fields[0], 16, 64)
+		if err != nil {
+			continue
+		}
+		name := strings.Join(fields[2:], " ")
+		syms = append(syms, &Sym{addr: addr, name: name})
+	}
+	return syms
+}
+
+// LoadSymsMap loads a symbol table previously saved with -syms, one
+// "addr name" pair per line.
+func LoadSymsMap(path string) Symbols {
+	f, err := os.Open(path)
+	check(err)
+	defer f.Close()
+
+	var syms Symbols
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		fields := strings.SplitN(scanner.Text(), " ", 2)
+		if len(fields) != 2 {
+			continue
+		}
+		addr, err := strconv.ParseUint(strings.TrimPrefix(fields[0], "0x"), 16, 64)
+		if err != nil {
+			log.Printf("skipping malformed symbol line %q", fields)
+			continue
+		}
+		syms = append(syms, &Sym{addr: addr, name: fields[1]})
+	}
+
+	sort.Slice(syms, func(i, j int) bool { return syms[i].addr < syms[j].addr })
+	return syms
+}
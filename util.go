@@ -0,0 +1,26 @@
+// Copyright 2011 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import "sort"
+
+// Sort orders list in place by ascending key(item). It exists so callers
+// that build up a []interface{} (e.g. graph edges) don't need to define a
+// one-off sort.Interface for each case.
+func Sort(list []interface{}, key func(interface{}) int) {
+	sort.Slice(list, func(i, j int) bool {
+		return key(list[i]) < key(list[j])
+	})
+}